@@ -0,0 +1,127 @@
+package epee
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/eapache/go-resiliency/breaker"
+)
+
+// BreakerConfig tunes the circuit breaker guarding a single endpoint
+// (the broker cluster or Zookeeper). A zero BreakerConfig is replaced
+// with sane defaults.
+type BreakerConfig struct {
+	// ErrorThreshold is the number of failures that trips the breaker
+	// open. The count resets only after Timeout passes without a
+	// failure; successes in between do not reset it.
+	ErrorThreshold int
+
+	// SuccessThreshold is the number of consecutive successes required,
+	// once the breaker is half-open, before it closes again.
+	SuccessThreshold int
+
+	// Timeout is how long the breaker stays open before allowing a
+	// trial request through.
+	Timeout time.Duration
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.ErrorThreshold == 0 {
+		c.ErrorThreshold = 5
+	}
+
+	if c.SuccessThreshold == 0 {
+		c.SuccessThreshold = 1
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	return c
+}
+
+// circuitBreaker wraps github.com/eapache/go-resiliency/breaker, adding
+// an atomically readable open flag so Health() doesn't need to trip the
+// breaker just to inspect it. The flag is set when a call is rejected
+// because the breaker is open and cleared by the next successful call,
+// so it does not change at the moment the breaker itself opens or
+// half-opens.
+type circuitBreaker struct {
+	b    *breaker.Breaker
+	open int32
+}
+
+func newCircuitBreaker(cfg BreakerConfig) *circuitBreaker {
+	cfg = cfg.withDefaults()
+	return &circuitBreaker{b: breaker.New(cfg.ErrorThreshold, cfg.SuccessThreshold, cfg.Timeout)}
+}
+
+func (c *circuitBreaker) run(f func() error) error {
+	err := c.b.Run(f)
+
+	if err == breaker.ErrBreakerOpen {
+		atomic.StoreInt32(&c.open, 1)
+		return ErrBrokerUnavailable
+	}
+
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&c.open, 0)
+	return nil
+}
+
+func (c *circuitBreaker) isOpen() bool {
+	return atomic.LoadInt32(&c.open) == 1
+}
+
+// breakerZookeeperClient decorates a ZookeeperClient, running every
+// call through a circuit breaker so a struggling ensemble fails fast
+// instead of piling up blocked callers.
+type breakerZookeeperClient struct {
+	zk ZookeeperClient
+	b  *circuitBreaker
+}
+
+// withBreaker wraps zk so every List/Get/Set call is guarded by a
+// circuit breaker tuned by cfg.
+func withBreaker(zk ZookeeperClient, cfg BreakerConfig) ZookeeperClient {
+	return &breakerZookeeperClient{zk: zk, b: newCircuitBreaker(cfg)}
+}
+
+func (z *breakerZookeeperClient) List(prefix string) ([]string, error) {
+	var paths []string
+	err := z.b.run(func() error {
+		var e error
+		paths, e = z.zk.List(prefix)
+		return e
+	})
+	return paths, err
+}
+
+func (z *breakerZookeeperClient) Get(path string, i interface{}) error {
+	return z.b.run(func() error { return z.zk.Get(path, i) })
+}
+
+func (z *breakerZookeeperClient) Set(path string, i interface{}) error {
+	return z.b.run(func() error { return z.zk.Set(path, i) })
+}
+
+// Health summarizes whether the broker cluster and Zookeeper look
+// reachable, based on the last call each of their circuit breakers
+// rejected or let succeed.
+type Health struct {
+	BrokerAvailable    bool
+	ZookeeperAvailable bool
+}
+
+// Health reports whether Consume and friends are likely to succeed
+// right now, so supervisors can react before consumers stall.
+func (ks *kafkaStreamImpl) Health() Health {
+	return Health{
+		BrokerAvailable:    !ks.brokerBreaker.isOpen(),
+		ZookeeperAvailable: !ks.zkBreaker.isOpen(),
+	}
+}
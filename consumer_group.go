@@ -0,0 +1,161 @@
+package epee
+
+import (
+	"log"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+// GroupConsumer is a Kafka consumer-group member. It fans the messages
+// of all partitions assigned to this instance by the group coordinator
+// onto a single channel, and transparently restarts its internal
+// consumers whenever the coordinator rebalances the group.
+type GroupConsumer struct {
+	sync.Mutex
+
+	// Messages is the channel callers should range over to receive
+	// messages from any partition assigned to this group member.
+	Messages chan Message
+
+	consumer *cluster.Consumer
+	done     chan struct{}
+}
+
+func newGroupConsumer(consumer *cluster.Consumer) *GroupConsumer {
+	return &GroupConsumer{
+		Messages: make(chan Message, 0),
+		consumer: consumer,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins draining the group's messages, notifications, and
+// errors. Rebalances are handled transparently by sarama-cluster itself;
+// we just log them here.
+func (gc *GroupConsumer) Start() {
+	go func() {
+		for {
+			select {
+			case msg, ok := <-gc.consumer.Messages():
+				if !ok {
+					return
+				}
+				select {
+				case gc.Messages <- (Message{
+					Topic:     msg.Topic,
+					Partition: int(msg.Partition),
+					Offset:    msg.Offset,
+					Key:       msg.Key,
+					Value:     msg.Value,
+				}):
+					gc.consumer.MarkOffset(msg, "")
+				case <-gc.done:
+					return
+				}
+			case <-gc.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case notification, ok := <-gc.consumer.Notifications():
+				if !ok {
+					return
+				}
+				log.Printf("INFO: Consumer group rebalanced. Current: %v", notification.Current)
+			case <-gc.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case err, ok := <-gc.consumer.Errors():
+				if !ok {
+					return
+				}
+				log.Printf("ERROR: Consumer group error: %v", err)
+			case <-gc.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close commits the group's offsets and leaves the group gracefully.
+func (gc *GroupConsumer) Close() error {
+	gc.Lock()
+	defer gc.Unlock()
+
+	select {
+	case <-gc.done:
+		return nil
+	default:
+		close(gc.done)
+	}
+
+	if err := gc.consumer.CommitOffsets(); err != nil {
+		log.Printf("WARNING: Failed to commit consumer group offsets on close. %v", err)
+	}
+
+	return gc.consumer.Close()
+}
+
+// ConsumeGroup joins groupID, subscribing to topics, and returns a
+// GroupConsumer whose Messages channel merges the output of every
+// partition the coordinator assigns to this instance. Partition
+// assignment (and reassignment on rebalance) is handled internally.
+func (ks *kafkaStreamImpl) ConsumeGroup(groupID string, topics []string) (*GroupConsumer, error) {
+	if ks.closing {
+		return nil, ErrStreamClosing
+	}
+
+	brokers, err := findRegisteredBrokers(ks.zk, ks.zkBreaker)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := cluster.NewConfig()
+	config.Consumer.Return.Errors = true
+	config.Group.Return.Notifications = true
+
+	consumer, err := cluster.NewConsumer(brokers, groupID, topics, config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gc := newGroupConsumer(consumer)
+
+	ks.Lock()
+	ks.groups[gc] = true
+	ks.Unlock()
+
+	gc.Start()
+
+	return gc, nil
+}
+
+// CancelConsumerGroup gracefully stops a group consumer, committing its
+// offsets before it leaves the group.
+func (ks *kafkaStreamImpl) CancelConsumerGroup(gc *GroupConsumer) error {
+	ks.Lock()
+	defer ks.Unlock()
+
+	_, ok := ks.groups[gc]
+
+	if ok {
+		gc.Close()
+		delete(ks.groups, gc)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package epee
+
+import (
+	"errors"
+	"log"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrNotAKafkaStreamImpl is returned by NewKafkaProducerFromStream when
+// stream wasn't built by NewKafkaStream, so there's no sarama.Client
+// underneath it to share.
+var ErrNotAKafkaStreamImpl = errors.New("stream does not expose a shared sarama.Client")
+
+// KafkaProducer publishes messages to Kafka, sharing broker discovery
+// and configuration with KafkaStream.
+type KafkaProducer interface {
+	// Send publishes a single message and blocks until Kafka has
+	// acknowledged it.
+	Send(topic string, key, value []byte) error
+
+	// SendAsync publishes a message without waiting for an ack; any
+	// resulting error is logged.
+	SendAsync(topic string, key, value []byte)
+
+	// Close flushes any in-flight messages and releases the underlying
+	// Sarama resources.
+	Close() error
+}
+
+type kafkaProducerImpl struct {
+	producer sarama.AsyncProducer
+
+	// client is the sarama.Client this producer owns and must close
+	// itself, or nil if it was built on a client owned elsewhere (e.g.
+	// a KafkaStream's, via NewKafkaProducerFromStream).
+	client sarama.Client
+
+	// done is closed once run has finished draining producer, so Close
+	// can wait for that before closing client.
+	done chan struct{}
+}
+
+func newKafkaProducer(producer sarama.AsyncProducer, client sarama.Client) *kafkaProducerImpl {
+	p := &kafkaProducerImpl{producer: producer, client: client, done: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+// run drains both the Successes and Errors channels. Messages sent via
+// Send carry a reply channel in their Metadata; run delivers the result
+// there. Messages sent via SendAsync carry no Metadata, so their errors
+// are simply logged.
+func (p *kafkaProducerImpl) run() {
+	defer close(p.done)
+
+	successes, errs := p.producer.Successes(), p.producer.Errors()
+
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+
+			if done, ok := msg.Metadata.(chan error); ok {
+				done <- nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			if done, ok := err.Msg.Metadata.(chan error); ok {
+				done <- err.Err
+			} else {
+				log.Printf("ERROR: Failed to publish message to %s: %v", err.Msg.Topic, err.Err)
+			}
+		}
+	}
+}
+
+func (p *kafkaProducerImpl) Send(topic string, key, value []byte) error {
+	done := make(chan error, 1)
+
+	p.producer.Input() <- &sarama.ProducerMessage{
+		Topic:    topic,
+		Key:      sarama.ByteEncoder(key),
+		Value:    sarama.ByteEncoder(value),
+		Metadata: done,
+	}
+
+	return <-done
+}
+
+func (p *kafkaProducerImpl) SendAsync(topic string, key, value []byte) {
+	p.producer.Input() <- &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.ByteEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	}
+}
+
+// Close flushes and shuts down the producer, then -- if this producer
+// owns its sarama.Client (built via NewKafkaProducer, not
+// NewKafkaProducerFromStream) -- closes that too, once run has fully
+// drained so we don't close the client out from under an in-flight
+// send.
+func (p *kafkaProducerImpl) Close() error {
+	p.producer.AsyncClose()
+	<-p.done
+
+	if p.client != nil {
+		return p.client.Close()
+	}
+
+	return nil
+}
+
+// NewKafkaProducer builds a KafkaProducer connected to the brokers
+// registered in zk, configured by getConfig (Snappy compression and a
+// hash partitioner). It opens its own sarama.Client,
+// independent of any KafkaStream; callers that already have a
+// KafkaStream and want to reuse its broker connection should use
+// NewKafkaProducerFromStream instead.
+func NewKafkaProducer(clientID string, zk ZookeeperClient) (KafkaProducer, error) {
+	brokers, err := findRegisteredBrokers(zk, newCircuitBreaker(BreakerConfig{}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := getConfig(clientID)
+
+	client, err := sarama.NewClient(brokers, config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewAsyncProducerFromClient(client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newKafkaProducer(producer, client), nil
+}
+
+// NewKafkaProducerFromStream builds a KafkaProducer from an
+// sarama.AsyncProducer created on stream's own sarama.Client, so the
+// producer and consumer side share one broker connection instead of
+// each opening their own. stream must have been built by
+// NewKafkaStream; ErrNotAKafkaStreamImpl is returned otherwise.
+func NewKafkaProducerFromStream(stream KafkaStream) (KafkaProducer, error) {
+	ks, ok := stream.(*kafkaStreamImpl)
+
+	if !ok || ks.client == nil {
+		return nil, ErrNotAKafkaStreamImpl
+	}
+
+	// Send waits for a delivery report on Successes, which Sarama only
+	// produces when Return.Successes is set on the client's config.
+	ks.client.Config().Producer.Return.Successes = true
+
+	producer, err := sarama.NewAsyncProducerFromClient(ks.client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newKafkaProducer(producer, nil), nil
+}
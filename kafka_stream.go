@@ -1,26 +1,38 @@
 package epee
 
 import (
-	"errors"
+	"context"
 	"github.com/Shopify/sarama"
 	"log"
 	"sync"
 	"time"
 )
 
-var (
-	ErrStreamClosing = errors.New("stream closing")
-)
-
 type KafkaStream interface {
 	// Close all resources associated with this thing.
-	Close()
+	Close(ctx context.Context)
 
 	// Returns a channel of messages to consume based on the client ID.
-	Consume(topic string, partition int, offset int64) (*StreamConsumer, error)
+	Consume(ctx context.Context, topic string, partition int, offset int64) (*StreamConsumer, error)
 
 	// Given a consumer, gracefully stops it.
-	CancelConsumer(*StreamConsumer) error
+	CancelConsumer(ctx context.Context, sc *StreamConsumer) error
+
+	// ConsumeTopic discovers every partition of topic and returns a
+	// single consumer whose Messages channel merges all of them, so
+	// callers don't need to know the partition layout up front.
+	ConsumeTopic(ctx context.Context, topic string, offset int64) (*StreamConsumer, error)
+
+	// Joins groupID and returns a consumer whose Messages channel merges
+	// the partitions the group coordinator assigns to this instance.
+	ConsumeGroup(groupID string, topics []string) (*GroupConsumer, error)
+
+	// Given a group consumer, gracefully leaves the group.
+	CancelConsumerGroup(*GroupConsumer) error
+
+	// Health reports whether the broker cluster and Zookeeper currently
+	// look reachable, based on the state of their circuit breakers.
+	Health() Health
 }
 
 type kafkaStreamImpl struct {
@@ -29,6 +41,9 @@ type kafkaStreamImpl struct {
 	// A list of stream consumers that have been created.
 	consumers map[*StreamConsumer]bool
 
+	// A list of consumer-group consumers that have been created.
+	groups map[*GroupConsumer]bool
+
 	// Indicates to child processes that we should continue running.
 	closing bool
 
@@ -40,20 +55,128 @@ type kafkaStreamImpl struct {
 
 	// The zookeeper cluster our service is connecting to.
 	zk ZookeeperClient
+
+	// The client ID we were constructed with, used to namespace
+	// checkpointed offsets in Zookeeper.
+	clientID string
+
+	// The codec used by ConsumeInto to decode message values.
+	codec Codec
+
+	// Circuit breakers guarding calls to the broker cluster and to
+	// Zookeeper, respectively.
+	brokerBreaker *circuitBreaker
+	zkBreaker     *circuitBreaker
 }
 
-func (ks *kafkaStreamImpl) Consume(topic string, partition int, offset int64) (*StreamConsumer, error) {
+func (ks *kafkaStreamImpl) Consume(ctx context.Context, topic string, partition int, offset int64) (*StreamConsumer, error) {
 	// If the stream is in the process of closing we don't want to start a new
 	// consumer.
 	if ks.closing {
 		return nil, ErrStreamClosing
 	}
 
-	if offset == 0 {
+	consumer, err := ks.consumePartition(ctx, topic, partition, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// We have to acquire the lock to modify the map.
+	ks.Lock()
+	ks.consumers[consumer] = true
+	ks.Unlock()
+
+	return consumer, nil
+}
+
+// ConsumeTopic discovers every partition of topic via the underlying
+// sarama.Consumer (which the mock consumer also implements) and starts
+// one internal consumer per partition, merging their messages onto the
+// returned StreamConsumer. Each partition still resumes independently
+// when offset is OffsetResume, and Ack/CommitOffset called on the
+// returned aggregate dispatch to the right per-partition child so each
+// partition's checkpoint is persisted independently too.
+func (ks *kafkaStreamImpl) ConsumeTopic(ctx context.Context, topic string, offset int64) (*StreamConsumer, error) {
+	if ks.closing {
+		return nil, ErrStreamClosing
+	}
+
+	partitions, err := ks.consumer.Partitions(topic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	agg := &StreamConsumer{
+		Messages:         make(chan Message, 0),
+		done:             make(chan struct{}),
+		childByPartition: make(map[int]*StreamConsumer),
+		codec:            ks.codec,
+	}
+
+	for _, p := range partitions {
+		child, err := ks.consumePartition(ctx, topic, int(p), offset)
+
+		if err != nil {
+			agg.Close(ctx)
+			return nil, err
+		}
+
+		agg.children = append(agg.children, child)
+		agg.childByPartition[int(p)] = child
+		go forwardMessages(agg, child)
+	}
+
+	ks.Lock()
+	ks.consumers[agg] = true
+	ks.Unlock()
+
+	return agg, nil
+}
+
+// forwardMessages copies every message child produces onto agg's
+// channel until child is exhausted or agg is closed.
+func forwardMessages(agg, child *StreamConsumer) {
+	for {
+		select {
+		case msg, ok := <-child.Messages:
+			if !ok {
+				return
+			}
+
+			select {
+			case agg.Messages <- msg:
+			case <-agg.done:
+				return
+			}
+		case <-agg.done:
+			return
+		}
+	}
+}
+
+// consumePartition starts a single partition consumer for (topic,
+// partition), resuming from a Zookeeper checkpoint when offset is
+// OffsetResume. It does not register the returned consumer with ks;
+// callers that should track it (Consume, ConsumeTopic) do that
+// themselves.
+func (ks *kafkaStreamImpl) consumePartition(ctx context.Context, topic string, partition int, offset int64) (*StreamConsumer, error) {
+	checkpointing := false
+
+	if offset == OffsetResume {
+		checkpointing = true
+		resolved, err := ks.resumeOffset(topic, partition)
+
+		if err != nil {
+			return nil, err
+		}
+
+		offset = resolved
+	} else if offset == 0 {
 		offset = sarama.OffsetOldest
 	}
 
-	var err error
 	var partitionConsumer sarama.PartitionConsumer
 
 	for {
@@ -61,12 +184,23 @@ func (ks *kafkaStreamImpl) Consume(topic string, partition int, offset int64) (*
 			break
 		}
 
-		partitionConsumer, err = ks.consumer.ConsumePartition(topic, int32(partition), offset)
+		err := ks.brokerBreaker.run(func() error {
+			var e error
+			partitionConsumer, e = ks.consumer.ConsumePartition(topic, int32(partition), offset)
+			return e
+		})
 
-		if err == sarama.ErrUnknownTopicOrPartition {
+		if err == ErrBrokerUnavailable {
+			return nil, err
+		} else if err == sarama.ErrUnknownTopicOrPartition {
 			log.Printf("WARNING: Failed to find [%s, partition %d]. Waiting, then retrying.", topic, partition)
-			<-time.After(5 * time.Second)
-			continue
+
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
 		} else if err != nil {
 			log.Printf("ERROR: Failed to start partition consumer. %v", err)
 			return nil, err
@@ -75,19 +209,37 @@ func (ks *kafkaStreamImpl) Consume(topic string, partition int, offset int64) (*
 
 	ch := make(chan Message, 0)
 	consumer := NewStreamConsumer(ch, partitionConsumer)
+	consumer.codec = ks.codec
 
-	// We have to acquire the lock to modify the map.
-	ks.Lock()
-	ks.consumers[consumer] = true
-	ks.Unlock()
+	if checkpointing {
+		consumer.withCheckpointing(ks.zk, ks.zkBreaker, ks.clientID, topic, partition, offset)
+	}
 
 	// Let's start the consumer up!
-	consumer.Start()
+	consumer.Start(ctx)
 
 	return consumer, nil
 }
 
-func (ks *kafkaStreamImpl) CancelConsumer(sc *StreamConsumer) error {
+// resumeOffset looks up the checkpointed offset for (topic, partition),
+// returning the offset to resume from (one past what was last
+// committed), or sarama.OffsetOldest if nothing has been checkpointed.
+func (ks *kafkaStreamImpl) resumeOffset(topic string, partition int) (int64, error) {
+	var stored int64
+	err := ks.zkBreaker.run(func() error {
+		return ks.zk.Get(offsetPath(ks.clientID, topic, partition), &stored)
+	})
+
+	if err == ErrNotFound {
+		return sarama.OffsetOldest, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return stored + 1, nil
+}
+
+func (ks *kafkaStreamImpl) CancelConsumer(ctx context.Context, sc *StreamConsumer) error {
 	ks.Lock()
 	defer ks.Unlock()
 
@@ -95,14 +247,14 @@ func (ks *kafkaStreamImpl) CancelConsumer(sc *StreamConsumer) error {
 	_, ok := ks.consumers[sc]
 
 	if ok {
-		sc.Close()
+		sc.Close(ctx)
 		delete(ks.consumers, sc)
 	}
 
 	return nil
 }
 
-func (ks *kafkaStreamImpl) Close() {
+func (ks *kafkaStreamImpl) Close(ctx context.Context) {
 	ks.Lock()
 	defer ks.Unlock()
 
@@ -111,15 +263,27 @@ func (ks *kafkaStreamImpl) Close() {
 	// Let's close all the created consumers.
 	for c := range ks.consumers {
 		// Wait for this consumer to close fully.
-		c.Close()
+		c.Close(ctx)
+	}
+
+	// Leave every consumer group we've joined.
+	for g := range ks.groups {
+		g.Close()
 	}
 
 	// Now all of the consumers should (theoretically) be done.
 	ks.consumer.Close()
 }
 
-func NewKafkaStream(clientID string, zk ZookeeperClient) (KafkaStream, error) {
-	brokers, err := findRegisteredBrokers(zk)
+// NewKafkaStream connects to the brokers registered in zk. breakerConfig
+// tunes the circuit breakers guarding calls to the broker cluster and to
+// Zookeeper; its zero value picks sane defaults. codec is optional; when
+// omitted, JSONCodec is used for ConsumeInto.
+func NewKafkaStream(clientID string, zk ZookeeperClient, breakerConfig BreakerConfig, codec ...Codec) (KafkaStream, error) {
+	zkBreaker := newCircuitBreaker(breakerConfig)
+	brokerBreaker := newCircuitBreaker(breakerConfig)
+
+	brokers, err := findRegisteredBrokers(zk, zkBreaker)
 
 	if err != nil {
 		return nil, err
@@ -129,7 +293,13 @@ func NewKafkaStream(clientID string, zk ZookeeperClient) (KafkaStream, error) {
 	config := sarama.NewConfig()
 	config.ClientID = clientID
 
-	client, err := sarama.NewClient(brokers, config)
+	var client sarama.Client
+
+	err = brokerBreaker.run(func() error {
+		var e error
+		client, e = sarama.NewClient(brokers, config)
+		return e
+	})
 
 	if err != nil {
 		return nil, err
@@ -146,6 +316,16 @@ func NewKafkaStream(clientID string, zk ZookeeperClient) (KafkaStream, error) {
 	stream.client = client
 	stream.consumer = consumer
 	stream.consumers = make(map[*StreamConsumer]bool)
+	stream.groups = make(map[*GroupConsumer]bool)
+	stream.zk = zk
+	stream.clientID = clientID
+	stream.codec = JSONCodec
+	stream.zkBreaker = zkBreaker
+	stream.brokerBreaker = brokerBreaker
+
+	if len(codec) > 0 {
+		stream.codec = codec[0]
+	}
 
 	return stream, nil
 }
\ No newline at end of file
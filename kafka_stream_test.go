@@ -0,0 +1,80 @@
+package epee
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestConsumeTopicDispatchesAckToPartitionChild(t *testing.T) {
+	zk := NewMockZookeeperClient()
+
+	stream, consumer := NewMockKafkaStream(t, "test-client", zk)
+	consumer.SetTopicMetadata(map[string][]int32{"test-topic": {0, 1}})
+
+	pc0 := consumer.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest)
+	pc1 := consumer.ExpectConsumePartition("test-topic", 1, sarama.OffsetOldest)
+
+	pc0.YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Partition: 0, Offset: 10, Value: []byte("{}")})
+	pc1.YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Partition: 1, Offset: 20, Value: []byte("{}")})
+
+	agg, err := stream.ConsumeTopic(context.Background(), "test-topic", OffsetResume)
+
+	if err != nil {
+		t.Fatalf("ConsumeTopic returned error: %v", err)
+	}
+
+	defer agg.Close(context.Background())
+
+	seen := make(map[int]Message)
+
+	for len(seen) < 2 {
+		msg := <-agg.Messages
+		seen[msg.Partition] = msg
+	}
+
+	for _, msg := range seen {
+		if err := agg.Ack(msg); err != nil {
+			t.Fatalf("Ack returned error: %v", err)
+		}
+	}
+
+	child0, child1 := agg.childByPartition[0], agg.childByPartition[1]
+
+	child0.Lock()
+	err = child0.flush()
+	committed0 := child0.committed
+	child0.Unlock()
+
+	if err != nil {
+		t.Fatalf("flush returned error for partition 0: %v", err)
+	}
+
+	child1.Lock()
+	err = child1.flush()
+	committed1 := child1.committed
+	child1.Unlock()
+
+	if err != nil {
+		t.Fatalf("flush returned error for partition 1: %v", err)
+	}
+
+	if committed0 != 10 || committed1 != 20 {
+		t.Fatalf("expected committed offsets 10 and 20, got %d and %d", committed0, committed1)
+	}
+
+	var stored0, stored1 int64
+
+	if err := zk.Get(offsetPath("test-client", "test-topic", 0), &stored0); err != nil {
+		t.Fatalf("failed to read back partition 0 checkpoint: %v", err)
+	}
+
+	if err := zk.Get(offsetPath("test-client", "test-topic", 1), &stored1); err != nil {
+		t.Fatalf("failed to read back partition 1 checkpoint: %v", err)
+	}
+
+	if stored0 != 10 || stored1 != 20 {
+		t.Fatalf("expected checkpoints 10 and 20 flushed to zk, got %d and %d", stored0, stored1)
+	}
+}
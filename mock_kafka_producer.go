@@ -0,0 +1,12 @@
+package epee
+
+import (
+	"github.com/Shopify/sarama/mocks"
+)
+
+func NewMockKafkaProducer(t mocks.ErrorReporter, clientID string) (KafkaProducer, *mocks.AsyncProducer) {
+	config := getConfig(clientID)
+	producer := mocks.NewAsyncProducer(t, config)
+
+	return newKafkaProducer(producer, nil), producer
+}
@@ -5,7 +5,7 @@ import (
 	"github.com/Shopify/sarama/mocks"
 )
 
-func NewMockKafkaStream(t mocks.ErrorReporter, clientID string, zk ZookeeperClient) (KafkaStream, *mocks.Consumer) {
+func NewMockKafkaStream(t mocks.ErrorReporter, clientID string, zk ZookeeperClient, codec ...Codec) (KafkaStream, *mocks.Consumer) {
 	config := sarama.NewConfig()
 	config.ClientID = clientID
 
@@ -15,6 +15,16 @@ func NewMockKafkaStream(t mocks.ErrorReporter, clientID string, zk ZookeeperClie
 	stream.client = nil
 	stream.consumer = consumer
 	stream.consumers = make(map[*StreamConsumer]bool)
+	stream.groups = make(map[*GroupConsumer]bool)
+	stream.zk = zk
+	stream.clientID = clientID
+	stream.codec = JSONCodec
+	stream.brokerBreaker = newCircuitBreaker(BreakerConfig{})
+	stream.zkBreaker = newCircuitBreaker(BreakerConfig{})
+
+	if len(codec) > 0 {
+		stream.codec = codec[0]
+	}
 
 	return stream, consumer
 }
\ No newline at end of file
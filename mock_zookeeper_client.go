@@ -6,6 +6,9 @@ import (
 	"path"
 )
 
+// mockZookeeperClient is an in-memory ZookeeperClient backed by a plain
+// map, for use in tests that need to exercise checkpoint read/write
+// paths without a real Zookeeper ensemble.
 type mockZookeeperClient struct {
 	paths map[string][]byte
 }
@@ -59,7 +62,10 @@ func (zk *mockZookeeperClient) Set(path string, i interface{}) error {
 	return err
 }
 
-func newMockZookeeperClient() ZookeeperClient {
+// NewMockZookeeperClient returns a ZookeeperClient backed by an
+// in-memory map instead of a real Zookeeper ensemble, so tests can
+// exercise resume-from-checkpoint and flush behavior directly.
+func NewMockZookeeperClient() ZookeeperClient {
 	zk := new(mockZookeeperClient)
 	zk.paths = make(map[string][]byte)
 	return zk
@@ -0,0 +1,313 @@
+package epee
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// DefaultCommitInterval is how often a checkpointing StreamConsumer
+	// flushes its committed offset to Zookeeper.
+	DefaultCommitInterval = 5 * time.Second
+
+	// DefaultCommitBatchSize is how many Acks a checkpointing
+	// StreamConsumer accumulates before forcing an early flush.
+	DefaultCommitBatchSize = 100
+)
+
+// Message is a single record consumed from Kafka.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+}
+
+// StreamConsumer drains a single sarama.PartitionConsumer onto a
+// Message channel until closed.
+type StreamConsumer struct {
+	sync.Mutex
+
+	// Messages is the channel callers should range over to receive
+	// messages.
+	Messages chan Message
+
+	// partitionConsumer is nil for a consumer returned by ConsumeTopic,
+	// which has no partition of its own and only fans in children.
+	partitionConsumer sarama.PartitionConsumer
+
+	// children are the per-partition consumers a ConsumeTopic-created
+	// aggregate forwards messages from; empty for an ordinary consumer.
+	children []*StreamConsumer
+
+	// childByPartition looks up the child in children responsible for a
+	// given partition, so Ack/CommitOffset on the aggregate checkpoint
+	// the right partition instead of conflating them all into one
+	// sequence; nil for an ordinary (non-aggregate) consumer.
+	childByPartition map[int]*StreamConsumer
+
+	done chan struct{}
+
+	// Checkpointing state. zk is nil unless the caller asked to resume
+	// from (and persist to) Zookeeper.
+	zk             ZookeeperClient
+	clientID       string
+	topic          string
+	partition      int
+	commitInterval time.Duration
+	commitBatch    int
+
+	// zkBreaker guards sc.zk.Set calls made by flush.
+	zkBreaker *circuitBreaker
+
+	pending        map[int64]bool
+	committed      int64
+	highestFlushed int64
+	sinceFlush     int
+
+	// baselineSet is false until committed/highestFlushed have been
+	// seeded, either from a resolved checkpoint offset (withCheckpointing)
+	// or, for a fresh consumer with no prior checkpoint, from the first
+	// Ack'd message's offset.
+	baselineSet bool
+
+	// codec decodes message values for ConsumeInto.
+	codec Codec
+}
+
+// NewStreamConsumer wraps pc, publishing every message it produces onto
+// ch until Close is called.
+func NewStreamConsumer(ch chan Message, pc sarama.PartitionConsumer) *StreamConsumer {
+	return &StreamConsumer{
+		Messages:           ch,
+		partitionConsumer:  pc,
+		done:               make(chan struct{}),
+		pending:            make(map[int64]bool),
+		commitInterval:     DefaultCommitInterval,
+		commitBatch:        DefaultCommitBatchSize,
+		codec:              JSONCodec,
+	}
+}
+
+// ConsumeInto blocks until a message arrives, then decodes its value
+// into v using the stream's configured Codec. It returns
+// ErrDecodingMessageFailed if decoding fails, and ctx.Err() or
+// ErrStreamClosing if ctx is canceled or the consumer is closed first.
+func (sc *StreamConsumer) ConsumeInto(ctx context.Context, v interface{}) (Message, error) {
+	select {
+	case msg, ok := <-sc.Messages:
+		if !ok {
+			return Message{}, ErrStreamClosing
+		}
+
+		if err := sc.codec.Unmarshal(msg.Value, v); err != nil {
+			return msg, ErrDecodingMessageFailed
+		}
+
+		return msg, nil
+	case <-sc.done:
+		return Message{}, ErrStreamClosing
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// withCheckpointing enables Zookeeper-backed offset checkpointing for
+// this consumer. It's called internally by KafkaStream.Consume when the
+// caller requests OffsetResume. breaker guards the flush calls this
+// consumer makes against zk, the same breaker KafkaStream itself uses
+// for Zookeeper, so Health().ZookeeperAvailable reflects the checkpoint
+// path too. startOffset is the offset the partition
+// consumer actually resumed from; when it's a concrete offset (resolved
+// from a prior checkpoint) committed/highestFlushed are seeded to
+// startOffset-1 so Ack's contiguous-offset tracking lines up with
+// reality immediately. When startOffset is a sarama sentinel (e.g.
+// OffsetOldest, because nothing had been checkpointed yet) the real
+// starting offset isn't known up front, so the baseline is instead
+// seeded lazily from the first Ack.
+func (sc *StreamConsumer) withCheckpointing(zk ZookeeperClient, breaker *circuitBreaker, clientID, topic string, partition int, startOffset int64) *StreamConsumer {
+	sc.zk = zk
+	sc.zkBreaker = breaker
+	sc.clientID = clientID
+	sc.topic = topic
+	sc.partition = partition
+
+	if startOffset >= 0 {
+		sc.committed = startOffset - 1
+		sc.highestFlushed = startOffset - 1
+		sc.baselineSet = true
+	}
+
+	return sc
+}
+
+// Start begins draining the underlying partition consumer. If
+// checkpointing is enabled, it also starts the periodic commit loop.
+// Both goroutines exit when ctx is canceled, in addition to the usual
+// Close path.
+func (sc *StreamConsumer) Start(ctx context.Context) {
+	go sc.consume(ctx)
+
+	if sc.zk != nil {
+		go sc.commitLoop(ctx)
+	}
+}
+
+func (sc *StreamConsumer) consume(ctx context.Context) {
+	for {
+		select {
+		case msg, ok := <-sc.partitionConsumer.Messages():
+			if !ok {
+				return
+			}
+
+			select {
+			case sc.Messages <- (Message{
+				Topic:     msg.Topic,
+				Partition: int(msg.Partition),
+				Offset:    msg.Offset,
+				Key:       msg.Key,
+				Value:     msg.Value,
+			}):
+			case <-sc.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		case <-sc.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Ack marks msg as fully processed so its offset becomes eligible for
+// checkpointing. Only the highest *contiguous* acked offset is ever
+// flushed, so a gap (an offset acked out of order) holds the checkpoint
+// back until it's filled in, guaranteeing at-least-once delivery on
+// resume. On a ConsumeTopic aggregate, Ack dispatches to the child
+// responsible for msg.Partition rather than checkpointing against the
+// aggregate's own state, which has no partition of its own.
+func (sc *StreamConsumer) Ack(msg Message) error {
+	if child, ok := sc.childByPartition[msg.Partition]; ok {
+		return child.Ack(msg)
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	if !sc.baselineSet {
+		sc.committed = msg.Offset - 1
+		sc.highestFlushed = msg.Offset - 1
+		sc.baselineSet = true
+	}
+
+	sc.pending[msg.Offset] = true
+
+	for sc.pending[sc.committed+1] {
+		sc.committed++
+		delete(sc.pending, sc.committed)
+	}
+
+	sc.sinceFlush++
+
+	if sc.sinceFlush >= sc.commitBatch {
+		sc.sinceFlush = 0
+		return sc.flush()
+	}
+
+	return nil
+}
+
+// CommitOffset explicitly checkpoints offset for (topic, partition),
+// bypassing the contiguous-ack bookkeeping that Ack performs. Most
+// callers should prefer Ack. On a ConsumeTopic aggregate, CommitOffset
+// dispatches to the child responsible for partition.
+func (sc *StreamConsumer) CommitOffset(topic string, partition int, offset int64) error {
+	if child, ok := sc.childByPartition[partition]; ok {
+		return child.CommitOffset(topic, partition, offset)
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	if sc.zk == nil || offset <= sc.committed {
+		return nil
+	}
+
+	sc.committed = offset
+	return sc.flush()
+}
+
+// flush persists the committed offset to Zookeeper. Callers must hold
+// sc.Mutex.
+func (sc *StreamConsumer) flush() error {
+	if sc.zk == nil || sc.committed <= sc.highestFlushed {
+		return nil
+	}
+
+	err := sc.zkBreaker.run(func() error {
+		return sc.zk.Set(offsetPath(sc.clientID, sc.topic, sc.partition), sc.committed)
+	})
+
+	if err == nil {
+		sc.highestFlushed = sc.committed
+	}
+
+	return err
+}
+
+func (sc *StreamConsumer) commitLoop(ctx context.Context) {
+	ticker := time.NewTicker(sc.commitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sc.Lock()
+			sc.flush()
+			sc.Unlock()
+		case <-sc.done:
+			sc.Lock()
+			sc.flush()
+			sc.Unlock()
+			return
+		case <-ctx.Done():
+			sc.Lock()
+			sc.flush()
+			sc.Unlock()
+			return
+		}
+	}
+}
+
+// Close stops draining the underlying partition consumer (and, for a
+// ConsumeTopic aggregate, every child consumer it fans in), flushing
+// any outstanding checkpoint first.
+func (sc *StreamConsumer) Close(ctx context.Context) {
+	sc.Lock()
+
+	select {
+	case <-sc.done:
+		sc.Unlock()
+		return
+	default:
+		sc.flush()
+		close(sc.done)
+	}
+
+	sc.Unlock()
+
+	for _, child := range sc.children {
+		child.Close(ctx)
+	}
+
+	if sc.partitionConsumer != nil {
+		sc.partitionConsumer.Close()
+	}
+}
@@ -0,0 +1,84 @@
+package epee
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestConsumeResumesFromCheckpointAndSeedsBaseline(t *testing.T) {
+	zk := NewMockZookeeperClient()
+
+	if err := zk.Set(offsetPath("test-client", "test-topic", 0), int64(4999)); err != nil {
+		t.Fatalf("failed to seed checkpoint: %v", err)
+	}
+
+	stream, consumer := NewMockKafkaStream(t, "test-client", zk)
+	consumer.ExpectConsumePartition("test-topic", 0, 5000)
+
+	sc, err := stream.Consume(context.Background(), "test-topic", 0, OffsetResume)
+
+	if err != nil {
+		t.Fatalf("Consume returned error: %v", err)
+	}
+
+	defer sc.Close(context.Background())
+
+	sc.Lock()
+	committed, baselineSet := sc.committed, sc.baselineSet
+	sc.Unlock()
+
+	if !baselineSet || committed != 4999 {
+		t.Fatalf("expected baseline seeded to 4999 on resume, got committed=%d baselineSet=%v", committed, baselineSet)
+	}
+}
+
+func TestAckAdvancesCommittedPastResumedCheckpoint(t *testing.T) {
+	zk := NewMockZookeeperClient()
+
+	if err := zk.Set(offsetPath("test-client", "test-topic", 0), int64(4999)); err != nil {
+		t.Fatalf("failed to seed checkpoint: %v", err)
+	}
+
+	stream, consumer := NewMockKafkaStream(t, "test-client", zk)
+	pc := consumer.ExpectConsumePartition("test-topic", 0, 5000)
+	pc.YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Partition: 0, Offset: 5000, Value: []byte("{}")})
+
+	sc, err := stream.Consume(context.Background(), "test-topic", 0, OffsetResume)
+
+	if err != nil {
+		t.Fatalf("Consume returned error: %v", err)
+	}
+
+	defer sc.Close(context.Background())
+
+	msg := <-sc.Messages
+
+	if err := sc.Ack(msg); err != nil {
+		t.Fatalf("Ack returned error: %v", err)
+	}
+
+	sc.Lock()
+	committed := sc.committed
+	err = sc.flush()
+	sc.Unlock()
+
+	if err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+
+	if committed != 5000 {
+		t.Fatalf("expected committed offset 5000 after acking the resumed message, got %d", committed)
+	}
+
+	var stored int64
+
+	if err := zk.Get(offsetPath("test-client", "test-topic", 0), &stored); err != nil {
+		t.Fatalf("failed to read back checkpoint: %v", err)
+	}
+
+	if stored != 5000 {
+		t.Fatalf("expected checkpoint 5000 flushed to zk, got %d", stored)
+	}
+}
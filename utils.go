@@ -1,9 +1,11 @@
 package epee
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/Shopify/sarama"
+	"log"
 	"time"
 )
 
@@ -11,22 +13,43 @@ var (
 	ErrDecodingMessageFailed = errors.New("message decoding failed")
 	ErrNotFound              = errors.New("not found")
 	ErrStreamClosing         = errors.New("stream closing")
+
+	// ErrBrokerUnavailable is returned instead of retrying when a
+	// circuit breaker guarding the broker cluster (or Zookeeper) is
+	// open.
+	ErrBrokerUnavailable = errors.New("broker unavailable")
 )
 
 const (
 	RetryForever = 0
+
+	// OffsetResume tells Consume to resume from the last checkpointed
+	// offset for this (clientID, topic, partition), falling back to
+	// sarama.OffsetOldest if nothing has been checkpointed yet.
+	OffsetResume int64 = -3
 )
 
+// offsetPath is the well-known Zookeeper path a consumer's checkpointed
+// offset for (topic, partition) is stored under.
+func offsetPath(clientID, topic string, partition int) string {
+	return fmt.Sprintf("/consumers/%s/offsets/%s/%d", clientID, topic, partition)
+}
+
 // Must open a Zookeeper connection within retry times. If retry <= 0, it will
-// retry for forever.
-func MustGetZookeeperClient(servers []string, retry int) ZookeeperClient {
+// retry for forever. ctx bounds the whole operation; if it's canceled while
+// waiting to retry, MustGetZookeeperClient panics with ctx.Err().
+//
+// Every List/Get/Set made through the returned client is guarded by a
+// circuit breaker tuned by breakerConfig, whose zero value picks sane
+// defaults; codec is forwarded to NewZookeeperClient as-is.
+func MustGetZookeeperClient(ctx context.Context, servers []string, retry int, breakerConfig BreakerConfig, codec ...Codec) ZookeeperClient {
 	var client ZookeeperClient
 	attempts := 0
 
 	for {
 		var err error
 
-		client, err = NewZookeeperClient(servers)
+		client, err = NewZookeeperClient(servers, codec...)
 
 		// Increment retry if need be.
 		if retry > 0 {
@@ -36,42 +59,66 @@ func MustGetZookeeperClient(servers []string, retry int) ZookeeperClient {
 		if err != nil && attempts > retry {
 			panic(err)
 		} else if err != nil {
-			<-time.After(3 * time.Second)
+			select {
+			case <-time.After(3 * time.Second):
+			case <-ctx.Done():
+				panic(ctx.Err())
+			}
 		} else {
 			// We found it, we're good!
 			break
 		}
 	}
 
-	return client
+	return withBreaker(client, breakerConfig)
 }
 
-func findRegisteredBrokers(zk ZookeeperClient) ([]string, error) {
-	paths, err := zk.List("/brokers/ids")
+// findRegisteredBrokers lists the brokers registered under
+// /brokers/ids, guarding every Zookeeper call with b. A broker
+// registration that fails to read is logged and skipped rather than
+// aborting the whole lookup; only a total failure (no brokers could be
+// read at all) is returned as an error.
+func findRegisteredBrokers(zk ZookeeperClient, b *circuitBreaker) ([]string, error) {
+	var paths []string
+
+	err := b.run(func() error {
+		var e error
+		paths, e = zk.List("/brokers/ids")
+		return e
+	})
 
 	if err != nil {
 		return []string{}, err
 	}
 
-	fullPaths := make([]string, 0)
+	fullPaths := make([]string, 0, len(paths))
+	var lastErr error
 
 	for _, p := range paths {
 		data := make(map[string]interface{})
-		err := zk.Get(p, data)
+
+		err := b.run(func() error { return zk.Get(p, data) })
 
 		if err != nil {
-			return []string{}, err
+			log.Printf("WARNING: Failed to read broker registration %s: %v", p, err)
+			lastErr = err
+			continue
 		}
 
 		fullPaths = append(fullPaths, fmt.Sprintf("%s:%0.0f", data["host"], data["port"]))
 	}
 
+	if len(fullPaths) == 0 && lastErr != nil {
+		return []string{}, lastErr
+	}
+
 	return fullPaths, nil
 }
 
 func getConfig(clientID string) *sarama.Config {
 	config := sarama.NewConfig()
 	config.Producer.Compression = sarama.CompressionSnappy
+	config.Producer.Return.Successes = true
 	config.ClientID = clientID
 	config.Producer.Partitioner = func(topic string) sarama.Partitioner {
 		return sarama.NewHashPartitioner(topic)
@@ -0,0 +1,131 @@
+package epee
+
+import (
+	"path"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ZookeeperClient abstracts the Zookeeper operations epee relies on:
+// discovering broker registrations and persisting small bits of
+// consumer state such as checkpointed offsets.
+type ZookeeperClient interface {
+	// List returns the full paths of every direct child of prefix.
+	List(prefix string) ([]string, error)
+
+	// Get unmarshals the value stored at path into i.
+	Get(path string, i interface{}) error
+
+	// Set marshals i and stores it at path, creating any missing
+	// parent nodes along the way.
+	Set(path string, i interface{}) error
+}
+
+type zookeeperClientImpl struct {
+	conn  *zk.Conn
+	codec Codec
+}
+
+// NewZookeeperClient connects to the given Zookeeper ensemble. codec is
+// optional and controls how Get/Set marshal stored values; it defaults
+// to JSONCodec.
+func NewZookeeperClient(servers []string, codec ...Codec) (ZookeeperClient, error) {
+	conn, _, err := zk.Connect(servers, 10*time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := JSONCodec
+
+	if len(codec) > 0 {
+		c = codec[0]
+	}
+
+	return &zookeeperClientImpl{conn: conn, codec: c}, nil
+}
+
+func (z *zookeeperClientImpl) List(prefix string) ([]string, error) {
+	children, _, err := z.conn.Children(prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(children))
+
+	for _, c := range children {
+		paths = append(paths, path.Join(prefix, c))
+	}
+
+	return paths, nil
+}
+
+func (z *zookeeperClientImpl) Get(p string, i interface{}) error {
+	data, _, err := z.conn.Get(p)
+
+	if err == zk.ErrNoNode {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return z.codec.Unmarshal(data, i)
+}
+
+func (z *zookeeperClientImpl) Set(p string, i interface{}) error {
+	data, err := z.codec.Marshal(i)
+
+	if err != nil {
+		return err
+	}
+
+	if err := z.ensurePath(path.Dir(p)); err != nil {
+		return err
+	}
+
+	exists, stat, err := z.conn.Exists(p)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		_, err = z.conn.Create(p, data, 0, zk.WorldACL(zk.PermAll))
+		return err
+	}
+
+	_, err = z.conn.Set(p, data, stat.Version)
+	return err
+}
+
+// ensurePath creates every missing node from the root down to p, as
+// plain empty znodes, so that Set can always write to a leaf.
+func (z *zookeeperClientImpl) ensurePath(p string) error {
+	if p == "/" || p == "." {
+		return nil
+	}
+
+	exists, _, err := z.conn.Exists(p)
+
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	if err := z.ensurePath(path.Dir(p)); err != nil {
+		return err
+	}
+
+	_, err = z.conn.Create(p, nil, 0, zk.WorldACL(zk.PermAll))
+
+	if err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+
+	return nil
+}